@@ -0,0 +1,1018 @@
+package unix
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/hcl"
+	"github.com/shirou/gopsutil/process"
+	"github.com/spiffe/spire/pkg/common/catalog"
+	workloadattestorv0 "github.com/spiffe/spire/proto/spire/agent/workloadattestor/v0"
+	spi "github.com/spiffe/spire/proto/spire/common/plugin"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxIDFileBytes bounds how much of an in-container /etc/passwd or
+// /etc/group we're willing to read, so a hostile or runaway workload can't
+// force the agent to buffer an unbounded file on every attestation.
+const maxIDFileBytes = 1 << 20 // 1 MiB
+
+// unknownIDSelectorValue is emitted in place of a resolved user/group name
+// when no name could be found for a uid/gid, so registration entries can
+// still match "runs as an unmapped id".
+const unknownIDSelectorValue = "unknown"
+
+const (
+	pluginName = "unix"
+)
+
+func BuiltIn() catalog.Plugin {
+	return builtin(New())
+}
+
+func builtin(p *Plugin) catalog.Plugin {
+	return catalog.MakePlugin(pluginName, workloadattestorv0.PluginServer(p))
+}
+
+// processInfo is implemented by the live process lookup as well as the
+// fakes used in tests.
+type processInfo interface {
+	Uids() ([]int32, error)
+	Gids() ([]int32, error)
+	Groups() ([]string, error)
+	Exe() (string, error)
+	NamespacedExe() string
+	RootDir() string
+	// Namespaces resolves the given namespace kinds (e.g. "mnt", "pid") to
+	// their inode number. A kind that can't be resolved (the /proc entry is
+	// missing, or isn't readable) is simply absent from the result; an
+	// error is returned only when namespace discovery fails outright for
+	// the process.
+	Namespaces(kinds []string) (map[string]string, error)
+	// SELinuxLabel returns the process' full SELinux context, or "" if
+	// SELinux is disabled, the context is "unconfined", or it couldn't be
+	// read for any other tolerable reason.
+	SELinuxLabel() (string, error)
+	// AppArmorProfile returns the process' "<profile> (<mode>)" AppArmor
+	// attribute, or "" if AppArmor is disabled, the process is
+	// "unconfined", or it couldn't be read for any other tolerable reason.
+	AppArmorProfile() (string, error)
+	// Mode returns the workload binary's file mode, including the setuid
+	// and setgid bits.
+	Mode() (os.FileMode, error)
+	// FileCaps returns the raw security.capability extended attribute of
+	// the workload binary, or nil if it isn't set.
+	FileCaps() ([]byte, error)
+}
+
+type configuration struct {
+	DiscoverWorkloadPath          bool     `hcl:"discover_workload_path"`
+	WorkloadSizeLimit             int64    `hcl:"workload_size_limit"`
+	ResolveNamesInWorkloadMountNS bool     `hcl:"resolve_names_in_workload_mount_ns"`
+	UnknownIDsPolicy              string   `hcl:"unknown_ids"`
+	NamespaceSelectors            []string `hcl:"namespace_selectors"`
+	DiscoverSELinuxLabel          bool     `hcl:"discover_selinux_label"`
+	DiscoverAppArmorProfile       bool     `hcl:"discover_apparmor_profile"`
+	WorkloadHashAlgorithms        []string `hcl:"workload_hash_algorithms"`
+	WorkloadHashCacheSize         int      `hcl:"workload_hash_cache_size"`
+	WorkloadHashCacheTTL          string   `hcl:"workload_hash_cache_ttl"`
+
+	// hashCacheSize and hashCacheTTL are the resolved (defaulted/parsed)
+	// values of WorkloadHashCacheSize/WorkloadHashCacheTTL, computed once
+	// in Configure.
+	hashCacheSize int
+	hashCacheTTL  time.Duration
+}
+
+// validHashAlgorithms are the digests workload_hash_algorithms is allowed
+// to name, mapped to the selector type prefix each one emits.
+var validHashAlgorithms = map[string]string{
+	"sha256":      "sha256",
+	"sha512":      "sha512",
+	"blake2b-256": "blake2b256",
+}
+
+const defaultWorkloadHashCacheSize = 1024
+const defaultWorkloadHashCacheTTL = 1 * time.Minute
+
+// validNamespaceKinds are the /proc/<pid>/ns/ entries namespace_selectors
+// is allowed to name.
+var validNamespaceKinds = map[string]bool{
+	"mnt":    true,
+	"pid":    true,
+	"user":   true,
+	"net":    true,
+	"ipc":    true,
+	"uts":    true,
+	"cgroup": true,
+}
+
+type Plugin struct {
+	log hclog.Logger
+
+	mu        sync.RWMutex
+	config    *configuration
+	hashCache *hashCache
+
+	hooks struct {
+		newProcess      func(pid int32) (processInfo, error)
+		lookupUserByID  func(id string) (*user.User, error)
+		lookupGroupByID func(id string) (*user.Group, error)
+	}
+}
+
+func New() *Plugin {
+	p := &Plugin{}
+	p.hooks.newProcess = newPSProcess
+	p.hooks.lookupUserByID = user.LookupId
+	p.hooks.lookupGroupByID = user.LookupGroupId
+	return p
+}
+
+func (p *Plugin) SetLogger(log hclog.Logger) {
+	p.log = log
+}
+
+func (p *Plugin) Attest(ctx context.Context, req *workloadattestorv0.AttestRequest) (*workloadattestorv0.AttestResponse, error) {
+	config, err := p.getConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proc, err := p.hooks.newProcess(req.Pid)
+	if err != nil {
+		return nil, p.newError("unable to get process: %v", err)
+	}
+
+	var selectorValues []string
+
+	uids, err := proc.Uids()
+	if err != nil {
+		return nil, p.newError("UIDs lookup: %v", err)
+	}
+	if len(uids) == 0 {
+		return nil, p.newError("UIDs lookup: no UIDs for process")
+	}
+	uid := uids[0]
+	if len(uids) > 1 {
+		uid = uids[1]
+	}
+	uidStr := strconv.FormatInt(int64(uid), 10)
+	selectorValues = append(selectorValues, "uid:"+uidStr)
+	userName, err := p.lookupUserName(proc, config, uidStr)
+	if err != nil {
+		return nil, err
+	}
+	selectorValues = append(selectorValues, "user:"+userName)
+
+	gids, err := proc.Gids()
+	if err != nil {
+		return nil, p.newError("GIDs lookup: %v", err)
+	}
+	if len(gids) == 0 {
+		return nil, p.newError("GIDs lookup: no GIDs for process")
+	}
+	gid := gids[0]
+	if len(gids) > 1 {
+		gid = gids[1]
+	}
+	gidStr := strconv.FormatInt(int64(gid), 10)
+	selectorValues = append(selectorValues, "gid:"+gidStr)
+	groupName, err := p.lookupGroupName(proc, config, gidStr)
+	if err != nil {
+		return nil, err
+	}
+	selectorValues = append(selectorValues, "group:"+groupName)
+
+	if config.DiscoverWorkloadPath {
+		exePath, err := proc.Exe()
+		if err != nil {
+			return nil, p.newError("path lookup: %v", err)
+		}
+		selectorValues = append(selectorValues, "path:"+exePath)
+
+		if config.WorkloadSizeLimit >= 0 {
+			algorithms := config.WorkloadHashAlgorithms
+			if len(algorithms) == 0 {
+				algorithms = []string{"sha256"}
+			}
+			digests, err := p.hashWorkload(proc, exePath, algorithms, config.WorkloadSizeLimit)
+			if err != nil {
+				return nil, p.newError("digest: %v", err)
+			}
+			for _, algorithm := range algorithms {
+				prefix := validHashAlgorithms[algorithm]
+				selectorValues = append(selectorValues, prefix+":"+digests[prefix])
+			}
+		}
+
+		mode, err := proc.Mode()
+		if err != nil {
+			return nil, p.newError("mode lookup: %v", err)
+		}
+		if mode&os.ModeSetuid != 0 {
+			selectorValues = append(selectorValues, "mode:setuid")
+		}
+		if mode&os.ModeSetgid != 0 {
+			selectorValues = append(selectorValues, "mode:setgid")
+		}
+
+		fileCapsXattr, err := proc.FileCaps()
+		if err != nil {
+			return nil, p.newError("file capabilities lookup: %v", err)
+		}
+		if fileCapsXattr != nil {
+			if caps, err := decodeFileCapabilities(fileCapsXattr); err != nil {
+				selectorValues = append(selectorValues, "file_cap:unknown")
+			} else {
+				selectorValues = append(selectorValues, caps.selectorValues()...)
+			}
+		}
+	}
+
+	if len(config.NamespaceSelectors) > 0 {
+		namespaces, err := proc.Namespaces(config.NamespaceSelectors)
+		if err != nil {
+			return nil, p.newError("namespace lookup: %v", err)
+		}
+		for _, kind := range config.NamespaceSelectors {
+			ino, ok := namespaces[kind]
+			if !ok {
+				p.log.Warn("Namespace selector unavailable", "namespace", kind)
+				continue
+			}
+			selectorValues = append(selectorValues, "ns:"+kind+":"+ino)
+		}
+	}
+
+	if config.DiscoverSELinuxLabel {
+		label, err := proc.SELinuxLabel()
+		if err != nil {
+			return nil, p.newError("SELinux label lookup: %v", err)
+		}
+		if label != "" {
+			selectorValues = append(selectorValues, "selinux:"+label)
+			if selinuxUser, selinuxRole, selinuxType, selinuxLevel, ok := parseSELinuxLabel(label); ok {
+				selectorValues = append(selectorValues,
+					"selinux_user:"+selinuxUser,
+					"selinux_role:"+selinuxRole,
+					"selinux_type:"+selinuxType,
+					"selinux_level:"+selinuxLevel,
+				)
+			}
+		}
+	}
+
+	if config.DiscoverAppArmorProfile {
+		profile, err := proc.AppArmorProfile()
+		if err != nil {
+			return nil, p.newError("AppArmor profile lookup: %v", err)
+		}
+		if profile != "" {
+			selectorValues = append(selectorValues, "apparmor:"+profile)
+		}
+	}
+
+	supplementaryGIDs, err := proc.Groups()
+	if err != nil {
+		return nil, p.newError("supplementary GIDs lookup: %v", err)
+	}
+	for _, supplementaryGID := range supplementaryGIDs {
+		selectorValues = append(selectorValues, "supplementary_gid:"+supplementaryGID)
+		supplementaryGroupName, err := p.lookupGroupName(proc, config, supplementaryGID)
+		if err != nil {
+			return nil, err
+		}
+		selectorValues = append(selectorValues, "supplementary_group:"+supplementaryGroupName)
+	}
+
+	var selectors []*spi.Selector
+	for _, value := range selectorValues {
+		selectors = append(selectors, &spi.Selector{
+			Type:  pluginName,
+			Value: value,
+		})
+	}
+
+	return &workloadattestorv0.AttestResponse{
+		Selectors: selectors,
+	}, nil
+}
+
+func (p *Plugin) Configure(ctx context.Context, req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
+	config := new(configuration)
+	if err := hcl.Decode(config, req.Configuration); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unix: unable to decode configuration: %v", err)
+	}
+
+	switch config.UnknownIDsPolicy {
+	case "":
+		config.UnknownIDsPolicy = "silent"
+	case "silent", "warn", "error":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unix: invalid value for unknown_ids: %q", config.UnknownIDsPolicy)
+	}
+
+	for _, kind := range config.NamespaceSelectors {
+		if !validNamespaceKinds[kind] {
+			return nil, status.Errorf(codes.InvalidArgument, "unix: invalid value for namespace_selectors: %q", kind)
+		}
+	}
+
+	for _, algorithm := range config.WorkloadHashAlgorithms {
+		if _, ok := validHashAlgorithms[algorithm]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unix: invalid value for workload_hash_algorithms: %q", algorithm)
+		}
+	}
+
+	switch {
+	case config.WorkloadHashCacheSize < 0:
+		config.hashCacheSize = 0
+	case config.WorkloadHashCacheSize == 0:
+		config.hashCacheSize = defaultWorkloadHashCacheSize
+	default:
+		config.hashCacheSize = config.WorkloadHashCacheSize
+	}
+
+	switch config.WorkloadHashCacheTTL {
+	case "":
+		config.hashCacheTTL = defaultWorkloadHashCacheTTL
+	default:
+		ttl, err := time.ParseDuration(config.WorkloadHashCacheTTL)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "unix: invalid value for workload_hash_cache_ttl: %v", err)
+		}
+		config.hashCacheTTL = ttl
+	}
+
+	p.setConfig(config)
+	p.setHashCache(newHashCache(config.hashCacheSize, config.hashCacheTTL))
+	return &spi.ConfigureResponse{}, nil
+}
+
+func (p *Plugin) GetPluginInfo(ctx context.Context, req *spi.GetPluginInfoRequest) (*spi.GetPluginInfoResponse, error) {
+	return &spi.GetPluginInfoResponse{}, nil
+}
+
+func (p *Plugin) getConfig() (*configuration, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.config == nil {
+		return &configuration{UnknownIDsPolicy: "silent"}, nil
+	}
+	return p.config, nil
+}
+
+func (p *Plugin) setConfig(config *configuration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+}
+
+func (p *Plugin) getHashCache() *hashCache {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.hashCache == nil {
+		return newHashCache(defaultWorkloadHashCacheSize, defaultWorkloadHashCacheTTL)
+	}
+	return p.hashCache
+}
+
+func (p *Plugin) setHashCache(cache *hashCache) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashCache = cache
+}
+
+func (p *Plugin) newError(format string, args ...interface{}) error {
+	return status.Errorf(codes.Unknown, "unix: "+format, args...)
+}
+
+// lookupUserName resolves a uid to a username. When the plugin is
+// configured to resolve names inside the workload's mount namespace, the
+// workload's own /etc/passwd is consulted first; otherwise (or if it
+// doesn't resolve the uid) the lookup falls back to the host. If the uid
+// can't be resolved anywhere, the unknown_ids policy decides whether that's
+// logged and/or fatal; unless it's fatal, the synthetic "unknown" name is
+// returned so the uid selector is never silently dropped.
+func (p *Plugin) lookupUserName(proc processInfo, config *configuration, uid string) (string, error) {
+	if config.ResolveNamesInWorkloadMountNS {
+		name, ok, err := lookupNameInContainer(proc.RootDir(), "etc/passwd", uid, 2)
+		if err != nil {
+			p.log.Warn("Failed to resolve user name in workload mount namespace", "uid", uid, "error", err)
+		} else if ok {
+			return name, nil
+		}
+	}
+
+	userInfo, err := p.hooks.lookupUserByID(uid)
+	if err == nil {
+		return userInfo.Username, nil
+	}
+
+	switch config.UnknownIDsPolicy {
+	case "error":
+		return "", p.newError("user name lookup: %v", err)
+	case "warn":
+		p.log.Warn("Failed to lookup user name by uid", "uid", uid, "error", err)
+	}
+	return unknownIDSelectorValue, nil
+}
+
+// lookupGroupName resolves a gid to a group name using the same
+// container-then-host strategy and unknown_ids policy as lookupUserName.
+func (p *Plugin) lookupGroupName(proc processInfo, config *configuration, gid string) (string, error) {
+	if config.ResolveNamesInWorkloadMountNS {
+		name, ok, err := lookupNameInContainer(proc.RootDir(), "etc/group", gid, 2)
+		if err != nil {
+			p.log.Warn("Failed to resolve group name in workload mount namespace", "gid", gid, "error", err)
+		} else if ok {
+			return name, nil
+		}
+	}
+
+	groupInfo, err := p.hooks.lookupGroupByID(gid)
+	if err == nil {
+		return groupInfo.Name, nil
+	}
+
+	switch config.UnknownIDsPolicy {
+	case "error":
+		return "", p.newError("group name lookup: %v", err)
+	case "warn":
+		p.log.Warn("Failed to lookup group name by gid", "gid", gid, "error", err)
+	}
+	return unknownIDSelectorValue, nil
+}
+
+// lookupNameInContainer searches relPath (e.g. "etc/passwd") rooted at
+// rootDir (e.g. /proc/<pid>/root) for a colon-separated record whose
+// idField column matches id, returning the name in the first column. A
+// missing file is reported as (_, false, nil) so callers can fall back to
+// the host lookup; any other error (including an oversized file) is
+// returned so the caller can decide how to treat it.
+func lookupNameInContainer(rootDir, relPath, id string, idField int) (string, bool, error) {
+	f, err := openNoFollow(rootDir, relPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	if fi.Size() > maxIDFileBytes {
+		return "", false, fmt.Errorf("%s exceeds size limit (%d > %d)", filepath.Join(rootDir, relPath), fi.Size(), maxIDFileBytes)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(f, maxIDFileBytes))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) <= idField {
+			continue
+		}
+		if fields[idField] == id {
+			return fields[0], true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", false, err
+	}
+	return "", false, nil
+}
+
+// openNoFollow opens relPath under rootDir, refusing to follow a symlink
+// planted in the final path component so a compromised workload can't
+// trick the agent into reading an arbitrary host file through its
+// /proc/<pid>/root view.
+func openNoFollow(rootDir, relPath string) (*os.File, error) {
+	dir, base := filepath.Split(relPath)
+	fullDir := filepath.Join(rootDir, dir)
+
+	dirFd, err := unix.Open(fullDir, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dirFd)
+
+	fd, err := unix.Openat(dirFd, base, unix.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(rootDir, relPath)), nil
+}
+
+// hashWorkload returns a digest for each requested algorithm, keyed by its
+// selector prefix, consulting (and populating) the hash cache so that
+// repeated attestations of the same on-disk binary don't re-read and
+// re-hash it.
+func (p *Plugin) hashWorkload(proc processInfo, exePath string, algorithms []string, limit int64) (map[string]string, error) {
+	f, err := os.Open(proc.NamespacedExe())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && fi.Size() > limit {
+		return nil, fmt.Errorf("workload %s exceeds size limit (%d > %d)", exePath, fi.Size(), limit)
+	}
+
+	cache := p.getHashCache()
+	key, cacheable := hashCacheKeyFromStat(fi)
+	if cacheable {
+		if digests, ok := cache.get(key); ok && hasAllDigests(digests, algorithms) {
+			return digests, nil
+		}
+	}
+
+	digests, err := hashFile(f, fi.Size(), algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		cache.put(key, digests)
+	}
+	return digests, nil
+}
+
+func hasAllDigests(digests map[string]string, algorithms []string) bool {
+	for _, algorithm := range algorithms {
+		if _, ok := digests[validHashAlgorithms[algorithm]]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hashFile computes every requested algorithm's digest in a single pass
+// over the file, mmap'ing it when possible so hashing a large binary
+// doesn't hold the whole thing in heap-allocated buffers.
+func hashFile(f *os.File, size int64, algorithms []string) (map[string]string, error) {
+	hashers := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		h, err := newHasher(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashers[algorithm] = h
+		writers = append(writers, h)
+	}
+	mw := io.MultiWriter(writers...)
+
+	if size > 0 {
+		if data, ok := mmapFile(f, size); ok {
+			defer unix.Munmap(data)
+			if _, err := mw.Write(data); err != nil {
+				return nil, err
+			}
+		} else if _, err := io.Copy(mw, f); err != nil {
+			return nil, err
+		}
+	}
+
+	digests := make(map[string]string, len(algorithms))
+	for _, algorithm := range algorithms {
+		digests[validHashAlgorithms[algorithm]] = hex.EncodeToString(hashers[algorithm].Sum(nil))
+	}
+	return digests, nil
+}
+
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake2b-256":
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// mmapFile maps the file's contents read-only. It returns ok=false (never
+// an error) on any failure, so the caller can fall back to a plain
+// buffered read.
+func mmapFile(f *os.File, size int64) (data []byte, ok bool) {
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// hashCacheKey identifies a file's content without reading it, so a
+// repeated attestation of an unchanged binary can skip hashing entirely.
+type hashCacheKey struct {
+	dev, ino uint64
+	mtime    int64
+	size     int64
+}
+
+func hashCacheKeyFromStat(fi os.FileInfo) (hashCacheKey, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return hashCacheKey{}, false
+	}
+	return hashCacheKey{
+		dev:   uint64(stat.Dev),
+		ino:   stat.Ino,
+		mtime: stat.Mtim.Nano(),
+		size:  fi.Size(),
+	}, true
+}
+
+// hashCache is a size- and TTL-bounded LRU cache from hashCacheKey to the
+// set of digests computed for that file.
+type hashCache struct {
+	mu      sync.Mutex
+	cap     int
+	ttl     time.Duration
+	ll      *list.List
+	entries map[hashCacheKey]*list.Element
+}
+
+type hashCacheEntry struct {
+	key     hashCacheKey
+	digests map[string]string
+	expires time.Time
+}
+
+func newHashCache(capacity int, ttl time.Duration) *hashCache {
+	return &hashCache{
+		cap:     capacity,
+		ttl:     ttl,
+		ll:      list.New(),
+		entries: make(map[hashCacheKey]*list.Element),
+	}
+}
+
+func (c *hashCache) get(key hashCacheKey) (map[string]string, bool) {
+	if c.cap <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*hashCacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.digests, true
+}
+
+func (c *hashCache) put(key hashCacheKey, digests map[string]string) {
+	if c.cap <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Time{}
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*hashCacheEntry)
+		entry.digests = digests
+		entry.expires = expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&hashCacheEntry{key: key, digests: digests, expires: expires})
+	c.entries[key] = el
+
+	if c.ll.Len() > c.cap {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.entries, oldest.Value.(*hashCacheEntry).key)
+		}
+	}
+}
+
+type psProcess struct {
+	p *process.Process
+}
+
+func (ps psProcess) Uids() ([]int32, error) {
+	return ps.p.Uids()
+}
+
+func (ps psProcess) Gids() ([]int32, error) {
+	return ps.p.Gids()
+}
+
+func (ps psProcess) Groups() ([]string, error) {
+	gids, err := ps.p.Groups()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, len(gids))
+	for i, gid := range gids {
+		groups[i] = strconv.FormatInt(int64(gid), 10)
+	}
+	return groups, nil
+}
+
+func (ps psProcess) Exe() (string, error) {
+	return ps.p.Exe()
+}
+
+func (ps psProcess) NamespacedExe() string {
+	return getProcPath(ps.p.Pid, "exe")
+}
+
+func (ps psProcess) RootDir() string {
+	return getProcPath(ps.p.Pid, "root")
+}
+
+func (ps psProcess) Namespaces(kinds []string) (map[string]string, error) {
+	namespaces := make(map[string]string)
+	for _, kind := range kinds {
+		target, err := os.Readlink(getProcPath(ps.p.Pid, filepath.Join("ns", kind)))
+		if err != nil {
+			continue
+		}
+		if ino, ok := parseNamespaceInode(target); ok {
+			namespaces[kind] = ino
+		}
+	}
+	return namespaces, nil
+}
+
+// parseSELinuxLabel splits a "user:role:type:level" SELinux context into
+// its components. level may itself contain colons (e.g. "s0:c123,c456"),
+// so it's taken as everything after the third colon.
+func parseSELinuxLabel(label string) (user, role, typ, level string, ok bool) {
+	fields := strings.SplitN(label, ":", 4)
+	if len(fields) != 4 {
+		return "", "", "", "", false
+	}
+	return fields[0], fields[1], fields[2], fields[3], true
+}
+
+// parseNamespaceInode extracts the inode number out of a namespace symlink
+// target of the form "mnt:[4026531835]".
+func parseNamespaceInode(target string) (string, bool) {
+	start := strings.IndexByte(target, '[')
+	end := strings.IndexByte(target, ']')
+	if start < 0 || end < start {
+		return "", false
+	}
+	return target[start+1 : end], true
+}
+
+// VFS_CAP_REVISION_* and VFS_CAP_FLAGS_EFFECTIVE, from
+// linux/capability.h, identify the binary layout of the
+// security.capability extended attribute.
+const (
+	vfsCapRevisionMask   = 0xFF000000
+	vfsCapRevision1      = 0x01000000
+	vfsCapRevision2      = 0x02000000
+	vfsCapRevision3      = 0x03000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// capabilityNames maps a capability's bit position (as defined by
+// linux/capability.h) to its lower-case "cap_xxx" name.
+var capabilityNames = map[int]string{
+	0:  "cap_chown",
+	1:  "cap_dac_override",
+	2:  "cap_dac_read_search",
+	3:  "cap_fowner",
+	4:  "cap_fsetid",
+	5:  "cap_kill",
+	6:  "cap_setgid",
+	7:  "cap_setuid",
+	8:  "cap_setpcap",
+	9:  "cap_linux_immutable",
+	10: "cap_net_bind_service",
+	11: "cap_net_broadcast",
+	12: "cap_net_admin",
+	13: "cap_net_raw",
+	14: "cap_ipc_lock",
+	15: "cap_ipc_owner",
+	16: "cap_sys_module",
+	17: "cap_sys_rawio",
+	18: "cap_sys_chroot",
+	19: "cap_sys_ptrace",
+	20: "cap_sys_pacct",
+	21: "cap_sys_admin",
+	22: "cap_sys_boot",
+	23: "cap_sys_nice",
+	24: "cap_sys_resource",
+	25: "cap_sys_time",
+	26: "cap_sys_tty_config",
+	27: "cap_mknod",
+	28: "cap_lease",
+	29: "cap_audit_write",
+	30: "cap_audit_control",
+	31: "cap_setfcap",
+	32: "cap_mac_override",
+	33: "cap_mac_admin",
+	34: "cap_syslog",
+	35: "cap_wake_alarm",
+	36: "cap_block_suspend",
+	37: "cap_audit_read",
+	38: "cap_perfmon",
+	39: "cap_bpf",
+	40: "cap_checkpoint_restore",
+}
+
+// fileCapabilities is the decoded form of a security.capability extended
+// attribute: the effective flag plus the 64-bit permitted and inheritable
+// capability bitmasks (the rootid carried by a revision 3 attribute isn't
+// needed for selector generation and is ignored).
+type fileCapabilities struct {
+	effective   bool
+	permitted   uint64
+	inheritable uint64
+}
+
+// decodeFileCapabilities parses the raw bytes of a security.capability
+// extended attribute, per the struct vfs_cap_data / vfs_ns_cap_data layout
+// documented in linux/capability.h.
+func decodeFileCapabilities(data []byte) (*fileCapabilities, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("file capability data too short (%d bytes)", len(data))
+	}
+	magicEtc := leUint32(data[0:4])
+	caps := &fileCapabilities{
+		effective: magicEtc&vfsCapFlagsEffective != 0,
+	}
+	switch magicEtc & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		if len(data) < 12 {
+			return nil, fmt.Errorf("file capability data too short for revision 1 (%d bytes)", len(data))
+		}
+		caps.permitted = uint64(leUint32(data[4:8]))
+		caps.inheritable = uint64(leUint32(data[8:12]))
+	case vfsCapRevision2, vfsCapRevision3:
+		if len(data) < 20 {
+			return nil, fmt.Errorf("file capability data too short for revision 2/3 (%d bytes)", len(data))
+		}
+		caps.permitted = uint64(leUint32(data[4:8])) | uint64(leUint32(data[12:16]))<<32
+		caps.inheritable = uint64(leUint32(data[8:12])) | uint64(leUint32(data[16:20]))<<32
+	default:
+		return nil, fmt.Errorf("unsupported file capability revision %#x", magicEtc&vfsCapRevisionMask)
+	}
+	return caps, nil
+}
+
+// selectorValues renders a file_cap selector for every named capability
+// bit set in the permitted or inheritable masks, followed by a
+// file_cap_effective selector when that bit is also effective, in ascending
+// bit order. Bits with no entry in capabilityNames are skipped.
+func (c *fileCapabilities) selectorValues() []string {
+	var values []string
+	for bit := 0; bit <= 40; bit++ {
+		mask := uint64(1) << uint(bit)
+		if c.permitted&mask == 0 && c.inheritable&mask == 0 {
+			continue
+		}
+		name, ok := capabilityNames[bit]
+		if !ok {
+			continue
+		}
+
+		var flags string
+		effective := c.effective && c.permitted&mask != 0
+		if effective {
+			flags += "e"
+		}
+		if c.inheritable&mask != 0 {
+			flags += "i"
+		}
+		if c.permitted&mask != 0 {
+			flags += "p"
+		}
+		values = append(values, "file_cap:"+name+"+"+flags)
+		if effective {
+			values = append(values, "file_cap_effective:"+name)
+		}
+	}
+	return values
+}
+
+func leUint32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func (ps psProcess) SELinuxLabel() (string, error) {
+	return readLSMAttr(getProcPath(ps.p.Pid, filepath.Join("attr", "current")))
+}
+
+func (ps psProcess) AppArmorProfile() (string, error) {
+	profile, err := readLSMAttr(getProcPath(ps.p.Pid, filepath.Join("attr", "apparmor", "current")))
+	if err != nil {
+		return "", err
+	}
+	if profile != "" {
+		return profile, nil
+	}
+	// Kernels without per-LSM attr directories expose the AppArmor
+	// attribute at the same legacy path SELinux uses.
+	return readLSMAttr(getProcPath(ps.p.Pid, filepath.Join("attr", "current")))
+}
+
+// readLSMAttr reads a /proc/<pid>/attr/* file, returning "" (with no
+// error) when the LSM isn't active for the process: the file doesn't
+// exist, is empty, or reads "unconfined".
+func readLSMAttr(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\x00\n")
+	if value == "" || value == "unconfined" {
+		return "", nil
+	}
+	return value, nil
+}
+
+func (ps psProcess) Mode() (os.FileMode, error) {
+	fi, err := os.Stat(ps.NamespacedExe())
+	if err != nil {
+		return 0, err
+	}
+	return fi.Mode(), nil
+}
+
+// FileCaps reads the workload binary's security.capability extended
+// attribute, returning (nil, nil) if the attribute isn't set.
+func (ps psProcess) FileCaps() ([]byte, error) {
+	path := ps.NamespacedExe()
+	size, err := unix.Getxattr(path, "security.capability", nil)
+	if err != nil {
+		if err == unix.ENODATA || err == unix.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	n, err := unix.Getxattr(path, "security.capability", buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func newPSProcess(pid int32) (processInfo, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	return psProcess{p: p}, nil
+}
+
+func getProcPath(pid int32, lastPath string) string {
+	return filepath.Join("/proc", strconv.Itoa(int(pid)), lastPath)
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -75,6 +78,18 @@ func (s *Suite) TestAttest() {
 			pid:  3,
 			selectors: []string{
 				"uid:1999",
+				"user:unknown",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "user lookup fails, unknown_ids = warn",
+			pid:    19,
+			config: "unknown_ids = \"warn\"",
+			selectors: []string{
+				"uid:1999",
+				"user:unknown",
 				"gid:2000",
 				"group:g2000",
 			},
@@ -90,6 +105,12 @@ func (s *Suite) TestAttest() {
 				},
 			},
 		},
+		{
+			name:   "user lookup fails, unknown_ids = error",
+			pid:    20,
+			config: "unknown_ids = \"error\"",
+			err:    "unix: user name lookup: no user with UID 1999",
+		},
 		{
 			name: "pid with no gids",
 			pid:  4,
@@ -107,6 +128,18 @@ func (s *Suite) TestAttest() {
 				"uid:1000",
 				"user:u1000",
 				"gid:2999",
+				"group:unknown",
+			},
+		},
+		{
+			name:   "group lookup fails, unknown_ids = warn",
+			pid:    21,
+			config: "unknown_ids = \"warn\"",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2999",
+				"group:unknown",
 			},
 			expectedLogs: []spiretest.LogEntry{
 				{
@@ -120,6 +153,12 @@ func (s *Suite) TestAttest() {
 				},
 			},
 		},
+		{
+			name:   "group lookup fails, unknown_ids = error",
+			pid:    22,
+			config: "unknown_ids = \"error\"",
+			err:    "unix: group name lookup: no group with GID 2999",
+		},
 		{
 			name: "primary user and gid",
 			pid:  7,
@@ -150,13 +189,13 @@ func (s *Suite) TestAttest() {
 			name:   "fail to hash process binary",
 			pid:    10,
 			config: "discover_workload_path = true",
-			err:    "unix: SHA256 digest: open /proc/10/unreadable-exe: no such file or directory",
+			err:    "unix: digest: open /proc/10/unreadable-exe: no such file or directory",
 		},
 		{
 			name:   "process binary exceeds size limits",
 			pid:    11,
 			config: "discover_workload_path = true\nworkload_size_limit = 2",
-			err:    fmt.Sprintf("unix: SHA256 digest: workload %s exceeds size limit (4 > 2)", filepath.Join(s.dir, "exe")),
+			err:    fmt.Sprintf("unix: digest: workload %s exceeds size limit (4 > 2)", filepath.Join(s.dir, "exe")),
 		},
 		{
 			name:   "success getting path and hashing process binary",
@@ -219,11 +258,341 @@ func (s *Suite) TestAttest() {
 			pid:  14,
 			err:  "unix: supplementary GIDs lookup: some error for PID 14",
 		},
+		{
+			name:   "name found only in container",
+			pid:    15,
+			config: "resolve_names_in_workload_mount_ns = true",
+			selectors: []string{
+				"uid:1999",
+				"user:cuser1999",
+				"gid:2999",
+				"group:cgroup2999",
+			},
+		},
+		{
+			name:   "name found only on host",
+			pid:    16,
+			config: "resolve_names_in_workload_mount_ns = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "container and host names differ, container wins",
+			pid:    17,
+			config: "resolve_names_in_workload_mount_ns = true",
+			selectors: []string{
+				"uid:1000",
+				"user:cuser1000",
+				"gid:2000",
+				"group:cgroup2000",
+			},
+		},
+		{
+			name:   "oversized container passwd file falls back to host",
+			pid:    18,
+			config: "resolve_names_in_workload_mount_ns = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+			expectedLogs: []spiretest.LogEntry{
+				{
+					Level:   logrus.WarnLevel,
+					Message: "Failed to resolve user name in workload mount namespace",
+					Data: logrus.Fields{
+						"uid":                   "1000",
+						logrus.ErrorKey:         fmt.Sprintf("%s exceeds size limit (%d > %d)", filepath.Join(s.dir, "containers", "18", "etc", "passwd"), maxIDFileBytes+1, maxIDFileBytes),
+						telemetry.SubsystemName: "built-in_plugin.unix",
+					},
+				},
+			},
+		},
+		{
+			name:   "multiple selected namespaces",
+			pid:    23,
+			config: "namespace_selectors = [\"mnt\", \"pid\", \"user\"]",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"ns:mnt:4026531835",
+				"ns:pid:4026531836",
+				"ns:user:4026531837",
+			},
+		},
+		{
+			name:   "missing namespace file",
+			pid:    24,
+			config: "namespace_selectors = [\"mnt\", \"net\"]",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"ns:mnt:4026531835",
+			},
+			expectedLogs: []spiretest.LogEntry{
+				{
+					Level:   logrus.WarnLevel,
+					Message: "Namespace selector unavailable",
+					Data: logrus.Fields{
+						"namespace":             "net",
+						telemetry.SubsystemName: "built-in_plugin.unix",
+					},
+				},
+			},
+		},
+		{
+			name:   "permission error reading namespace",
+			pid:    25,
+			config: "namespace_selectors = [\"mnt\", \"user\"]",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"ns:mnt:4026531835",
+			},
+			expectedLogs: []spiretest.LogEntry{
+				{
+					Level:   logrus.WarnLevel,
+					Message: "Namespace selector unavailable",
+					Data: logrus.Fields{
+						"namespace":             "user",
+						telemetry.SubsystemName: "built-in_plugin.unix",
+					},
+				},
+			},
+		},
+		{
+			name:   "fail to read namespaces",
+			pid:    26,
+			config: "namespace_selectors = [\"mnt\"]",
+			err:    "unix: namespace lookup: unable to read namespaces for PID 26",
+		},
+		{
+			name:   "full SELinux label",
+			pid:    27,
+			config: "discover_selinux_label = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"selinux:system_u:system_r:container_t:s0:c123,c456",
+				"selinux_user:system_u",
+				"selinux_role:system_r",
+				"selinux_type:container_t",
+				"selinux_level:s0:c123,c456",
+			},
+		},
+		{
+			name:   "SELinux disabled (no attr file)",
+			pid:    28,
+			config: "discover_selinux_label = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "SELinux unconfined",
+			pid:    29,
+			config: "discover_selinux_label = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "SELinux malformed label",
+			pid:    30,
+			config: "discover_selinux_label = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"selinux:not-a-valid-label",
+			},
+		},
+		{
+			name:   "full AppArmor profile",
+			pid:    31,
+			config: "discover_apparmor_profile = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				"apparmor:docker-default (enforce)",
+			},
+		},
+		{
+			name:   "AppArmor disabled (no attr file)",
+			pid:    32,
+			config: "discover_apparmor_profile = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "AppArmor unconfined",
+			pid:    33,
+			config: "discover_apparmor_profile = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+			},
+		},
+		{
+			name:   "multiple hash algorithms, in configured order",
+			pid:    34,
+			config: "discover_workload_path = true\nworkload_hash_algorithms = [\"sha512\", \"sha256\", \"blake2b-256\"]",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha512:77c7ce9a5d86bb386d443bb96390faa120633158699c8844c30b13ab0bf92760b7e4416aea397db91b4ac0e5dd56b8ef7e4b066162ab1fdc088319ce6defc876",
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"blake2b256:a035872d6af8639ede962dfe7536b0c150b590f3234a922fb7064cd11971b58e",
+			},
+		},
+		{
+			name:   "hash algorithm and size limit both apply, limit enforced before hashing",
+			pid:    34,
+			config: "discover_workload_path = true\nworkload_hash_algorithms = [\"sha512\", \"blake2b-256\"]\nworkload_size_limit = 2",
+			err:    fmt.Sprintf("unix: digest: workload %s exceeds size limit (4 > 2)", filepath.Join(s.dir, "exe")),
+		},
+		{
+			name:   "no setuid/setgid bits, no file capabilities",
+			pid:    35,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+			},
+		},
+		{
+			name:   "setuid binary",
+			pid:    36,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"mode:setuid",
+			},
+		},
+		{
+			name:   "setgid binary",
+			pid:    37,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"mode:setgid",
+			},
+		},
+		{
+			name:   "revision 2 file capabilities, effective",
+			pid:    38,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"file_cap:cap_net_bind_service+ep",
+				"file_cap_effective:cap_net_bind_service",
+			},
+		},
+		{
+			name:   "revision 3 file capabilities with rootid, not effective",
+			pid:    39,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"file_cap:cap_net_admin+p",
+				"file_cap:cap_sys_admin+i",
+			},
+		},
+		{
+			name:   "unrecognized file capability revision",
+			pid:    40,
+			config: "discover_workload_path = true",
+			selectors: []string{
+				"uid:1000",
+				"user:u1000",
+				"gid:2000",
+				"group:g2000",
+				fmt.Sprintf("path:%s", filepath.Join(s.dir, "exe")),
+				"sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+				"file_cap:unknown",
+			},
+		},
+		{
+			name:   "fail to get file mode",
+			pid:    41,
+			config: "discover_workload_path = true",
+			err:    "unix: mode lookup: unable to stat workload binary for PID 41",
+		},
+		{
+			name:   "fail to get file capabilities",
+			pid:    42,
+			config: "discover_workload_path = true",
+			err:    "unix: file capabilities lookup: unable to read file capabilities for PID 42",
+		},
 	}
 
 	// prepare the "exe" for hashing
 	s.writeFile("exe", []byte("data"))
 
+	// prepare the in-container /etc/passwd and /etc/group files used by the
+	// resolve_names_in_workload_mount_ns cases (pid 16 deliberately has no
+	// container root at all, to exercise the missing-file fallback)
+	s.writeContainerFile(15, "etc/passwd", "cuser1999:x:1999:1999::/home/cuser1999:/bin/sh\n")
+	s.writeContainerFile(15, "etc/group", "cgroup2999:x:2999:\n")
+	s.writeContainerFile(17, "etc/passwd", "cuser1000:x:1000:1000::/home/cuser1000:/bin/sh\n")
+	s.writeContainerFile(17, "etc/group", "cgroup2000:x:2000:\n")
+	s.writeContainerFile(18, "etc/passwd", strings.Repeat("a", maxIDFileBytes+1))
+
 	for _, testCase := range testCases {
 		testCase := testCase
 		s.T().Run(testCase.name, func(t *testing.T) {
@@ -259,12 +628,81 @@ func (s *Suite) TestConfigure() {
 	s.AssertProtoEqual(&spi.ConfigureResponse{}, resp)
 }
 
+func (s *Suite) TestConfigureRejectsInvalidUnknownIDsPolicy() {
+	_, err := s.p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: `unknown_ids = "bogus"`,
+	})
+	spiretest.RequireGRPCStatus(s.T(), err, codes.InvalidArgument, `unix: invalid value for unknown_ids: "bogus"`)
+}
+
+func (s *Suite) TestConfigureRejectsInvalidWorkloadHashAlgorithm() {
+	_, err := s.p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: `workload_hash_algorithms = ["sha256", "md5"]`,
+	})
+	spiretest.RequireGRPCStatus(s.T(), err, codes.InvalidArgument, `unix: invalid value for workload_hash_algorithms: "md5"`)
+}
+
+func (s *Suite) TestConfigureRejectsInvalidWorkloadHashCacheTTL() {
+	_, err := s.p.Configure(ctx, &spi.ConfigureRequest{
+		Configuration: `workload_hash_cache_ttl = "not-a-duration"`,
+	})
+	spiretest.RequireGRPCStatus(s.T(), err, codes.InvalidArgument, `unix: invalid value for workload_hash_cache_ttl: time: invalid duration "not-a-duration"`)
+}
+
 func (s *Suite) TestGetPluginInfo() {
 	resp, e := s.p.GetPluginInfo(ctx, &spi.GetPluginInfoRequest{})
 	s.NoError(e)
 	s.AssertProtoEqual(&spi.GetPluginInfoResponse{}, resp)
 }
 
+// TestHashWorkloadCache exercises the hash cache by calling hashWorkload
+// directly against a real file on disk, since the table-driven Attest cases
+// above only see the emitted selectors and can't observe whether a digest
+// was actually recomputed.
+func TestHashWorkloadCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-hash-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	require.NoError(t, ioutil.WriteFile(exePath, []byte("data"), 0755))
+	fi, err := os.Stat(exePath)
+	require.NoError(t, err)
+	mtime := fi.ModTime()
+
+	p := New()
+	p.setHashCache(newHashCache(10, time.Minute))
+	proc := fakeProcess{pid: 34, dir: dir}
+
+	digests, err := p.hashWorkload(proc, exePath, []string{"sha256"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", digests["sha256"])
+
+	// Overwrite with same-length content but restore the original mtime:
+	// the cache key (dev, inode, mtime, size) is unchanged, so the stale
+	// cached digest is returned instead of being recomputed.
+	require.NoError(t, ioutil.WriteFile(exePath, []byte("atad"), 0755))
+	require.NoError(t, os.Chtimes(exePath, mtime, mtime))
+	digests, err = p.hashWorkload(proc, exePath, []string{"sha256"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", digests["sha256"])
+
+	// Bumping the mtime invalidates the stale cache entry and the new
+	// content is hashed.
+	newMtime := mtime.Add(time.Second)
+	require.NoError(t, os.Chtimes(exePath, newMtime, newMtime))
+	digests, err = p.hashWorkload(proc, exePath, []string{"sha256"}, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", digests["sha256"])
+
+	// A cache of size zero never stores anything, so every call recomputes
+	// straight from disk.
+	p.setHashCache(newHashCache(0, time.Minute))
+	digests, err = p.hashWorkload(proc, exePath, []string{"sha256", "sha512"}, 0)
+	require.NoError(t, err)
+	require.Len(t, digests, 2)
+}
+
 func (s *Suite) configure(config string) {
 	_, err := s.p.Configure(ctx, &spi.ConfigureRequest{
 		Configuration: config,
@@ -276,6 +714,15 @@ func (s *Suite) writeFile(path string, data []byte) {
 	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, path), data, 0600))
 }
 
+// writeContainerFile writes relPath under the fake workload-root for pid
+// (i.e. what fakeProcess.RootDir() returns for that pid), creating any
+// intermediate directories.
+func (s *Suite) writeContainerFile(pid int32, relPath string, data string) {
+	fullPath := filepath.Join(s.dir, "containers", strconv.Itoa(int(pid)), relPath)
+	s.Require().NoError(os.MkdirAll(filepath.Dir(fullPath), 0700))
+	s.Require().NoError(ioutil.WriteFile(fullPath, []byte(data), 0600))
+}
+
 type fakeProcess struct {
 	pid int32
 	dir string
@@ -287,12 +734,14 @@ func (p fakeProcess) Uids() ([]int32, error) {
 		return []int32{}, nil
 	case 2:
 		return nil, fmt.Errorf("unable to get UIDs for PID %d", p.pid)
-	case 3:
+	case 3, 19, 20:
 		return []int32{1999}, nil
-	case 4, 5, 6, 7, 9, 10, 11, 12, 13, 14:
+	case 4, 5, 6, 7, 9, 10, 11, 12, 13, 14, 16, 17, 18, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42:
 		return []int32{1000}, nil
 	case 8:
 		return []int32{1000, 1100}, nil
+	case 15:
+		return []int32{1999}, nil
 	default:
 		return nil, fmt.Errorf("unhandled uid test case %d", p.pid)
 	}
@@ -304,12 +753,14 @@ func (p fakeProcess) Gids() ([]int32, error) {
 		return []int32{}, nil
 	case 5:
 		return nil, fmt.Errorf("unable to get GIDs for PID %d", p.pid)
-	case 6:
+	case 6, 21, 22:
 		return []int32{2999}, nil
-	case 3, 7, 9, 10, 11, 12, 13, 14:
+	case 3, 7, 9, 10, 11, 12, 13, 14, 16, 17, 18, 19, 20, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42:
 		return []int32{2000}, nil
 	case 8:
 		return []int32{2000, 2100}, nil
+	case 15:
+		return []int32{2999}, nil
 	default:
 		return nil, fmt.Errorf("unhandled gid test case %d", p.pid)
 	}
@@ -332,7 +783,7 @@ func (p fakeProcess) Exe() (string, error) {
 		return "", fmt.Errorf("unable to get EXE for PID %d", p.pid)
 	case 10:
 		return filepath.Join(p.dir, "unreadable-exe"), nil
-	case 11, 12:
+	case 11, 12, 34, 35, 36, 37, 38, 39, 40, 41, 42:
 		return filepath.Join(p.dir, "exe"), nil
 	default:
 		return "", fmt.Errorf("unhandled exe test case %d", p.pid)
@@ -341,13 +792,129 @@ func (p fakeProcess) Exe() (string, error) {
 
 func (p fakeProcess) NamespacedExe() string {
 	switch p.pid {
-	case 11, 12:
+	case 11, 12, 34, 35, 36, 37, 38, 39, 40, 41, 42:
 		return filepath.Join(p.dir, "exe")
 	default:
 		return filepath.Join("/proc", strconv.Itoa(int(p.pid)), "unreadable-exe")
 	}
 }
 
+func (p fakeProcess) RootDir() string {
+	return filepath.Join(p.dir, "containers", strconv.Itoa(int(p.pid)))
+}
+
+func (p fakeProcess) Namespaces(kinds []string) (map[string]string, error) {
+	all := map[string]string{
+		"mnt":  "4026531835",
+		"pid":  "4026531836",
+		"user": "4026531837",
+		"net":  "4026531840",
+	}
+	switch p.pid {
+	case 23:
+		// all requested namespaces are available
+	case 24:
+		// "net" has no /proc/<pid>/ns entry
+		delete(all, "net")
+	case 25:
+		// "user" is present but not readable by the agent
+		delete(all, "user")
+	case 26:
+		return nil, fmt.Errorf("unable to read namespaces for PID %d", p.pid)
+	default:
+		return nil, fmt.Errorf("unhandled namespaces test case %d", p.pid)
+	}
+
+	result := make(map[string]string)
+	for _, kind := range kinds {
+		if ino, ok := all[kind]; ok {
+			result[kind] = ino
+		}
+	}
+	return result, nil
+}
+
+func (p fakeProcess) SELinuxLabel() (string, error) {
+	switch p.pid {
+	case 27:
+		return "system_u:system_r:container_t:s0:c123,c456", nil
+	case 28:
+		return "", nil // no /proc/<pid>/attr/current
+	case 29:
+		return "", nil // unconfined
+	case 30:
+		return "not-a-valid-label", nil
+	default:
+		return "", fmt.Errorf("unhandled selinux test case %d", p.pid)
+	}
+}
+
+func (p fakeProcess) AppArmorProfile() (string, error) {
+	switch p.pid {
+	case 31:
+		return "docker-default (enforce)", nil
+	case 32:
+		return "", nil // no /proc/<pid>/attr/apparmor/current or legacy path
+	case 33:
+		return "", nil // unconfined
+	default:
+		return "", fmt.Errorf("unhandled apparmor test case %d", p.pid)
+	}
+}
+
+func (p fakeProcess) Mode() (os.FileMode, error) {
+	switch p.pid {
+	case 36:
+		return 0o755 | os.ModeSetuid, nil
+	case 37:
+		return 0o755 | os.ModeSetgid, nil
+	case 41:
+		return 0, fmt.Errorf("unable to stat workload binary for PID %d", p.pid)
+	default:
+		return 0o755, nil
+	}
+}
+
+// leUint32Bytes little-endian encodes v, matching the on-disk layout of the
+// security.capability extended attribute that decodeFileCapabilities parses.
+func leUint32Bytes(v uint32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
+
+func (p fakeProcess) FileCaps() ([]byte, error) {
+	switch p.pid {
+	case 12, 34, 35, 36, 37:
+		return nil, nil // no security.capability xattr
+	case 38:
+		// Revision 2, effective, cap_net_bind_service permitted only.
+		var data []byte
+		data = append(data, leUint32Bytes(vfsCapRevision2|vfsCapFlagsEffective)...)
+		data = append(data, leUint32Bytes(1<<10)...) // permitted, low 32 bits
+		data = append(data, leUint32Bytes(0)...)     // inheritable, low 32 bits
+		data = append(data, leUint32Bytes(0)...)     // permitted, high 32 bits
+		data = append(data, leUint32Bytes(0)...)     // inheritable, high 32 bits
+		return data, nil
+	case 39:
+		// Revision 3 (with rootid), not effective: cap_net_admin permitted,
+		// cap_sys_admin inheritable.
+		var data []byte
+		data = append(data, leUint32Bytes(vfsCapRevision3)...)
+		data = append(data, leUint32Bytes(1<<12)...) // permitted, low 32 bits (cap_net_admin)
+		data = append(data, leUint32Bytes(1<<21)...) // inheritable, low 32 bits (cap_sys_admin)
+		data = append(data, leUint32Bytes(0)...)     // permitted, high 32 bits
+		data = append(data, leUint32Bytes(0)...)     // inheritable, high 32 bits
+		data = append(data, leUint32Bytes(1000)...)  // rootid, ignored by the decoder
+		return data, nil
+	case 40:
+		// Unrecognized revision.
+		return leUint32Bytes(0x04000000), nil
+	case 42:
+		return nil, fmt.Errorf("unable to read file capabilities for PID %d", p.pid)
+	default:
+		return nil, fmt.Errorf("unhandled file capabilities test case %d", p.pid)
+	}
+}
+
 func newFakeProcess(pid int32, dir string) processInfo {
 	return fakeProcess{pid: pid, dir: dir}
 }